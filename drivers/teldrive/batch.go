@@ -0,0 +1,222 @@
+package teldrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/errs"
+	"github.com/AliciaLEO/alist-pro/v3/internal/model"
+)
+
+// apiError保留一次失败请求的HTTP状态码和原始响应体，让isNonEmptyFolderErr这类调用方可以
+// 按状态码判断，而不是去猜服务端本地化后的错误文案。
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("请求失败(status %d): %s", e.StatusCode, e.Body)
+}
+
+// Copy 通过/api/files/copy做服务端直接复制，请求体与Move一样是批量ids+目标父目录，
+// 这样alist的复制任务就不会对同一个TelDrive存储内的复制退化成“下载再上传”。
+func (d *TelDrive) Copy(ctx context.Context, srcObj, dstDir model.Obj) (model.Obj, error) {
+	telObj, ok := srcObj.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("无效的源对象类型")
+	}
+
+	dstDirObj, ok := dstDir.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("无效的目标目录类型")
+	}
+
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"destinationParent": dstDirObj.ID,
+			"ids":               []string{telObj.ID},
+		}).
+		Post("/api/files/copy")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("复制文件失败: %s", resp.String())
+	}
+
+	newID, err := parseCopiedFileID(resp.Body())
+	if err != nil {
+		return nil, fmt.Errorf("复制文件成功但解析返回结果失败: %v", err)
+	}
+
+	newObj := *telObj
+	newObj.ID = newID
+	newObj.ParentID = dstDirObj.ID
+	newObj.Path = path.Join(dstDirObj.Path, telObj.Name)
+
+	return &newObj, nil
+}
+
+// parseCopiedFileID 兼容/api/files/copy可能返回单个对象，也可能返回{"items":[...]}批量结果。
+func parseCopiedFileID(body []byte) (string, error) {
+	var batch struct {
+		Items []FileInfo `json:"items"`
+	}
+	if json.Unmarshal(body, &batch) == nil && len(batch.Items) > 0 {
+		return batch.Items[0].Id, nil
+	}
+
+	var single FileInfo
+	if json.Unmarshal(body, &single) == nil && single.Id != "" {
+		return single.Id, nil
+	}
+
+	return "", fmt.Errorf("未能从响应中识别新文件ID: %s", string(body))
+}
+
+// BatchRemove一次性删除多个对象，对应TelDrive一次DELETE /api/files可以接受多个ids的能力，
+// 避免像Move/Rename那样逐个对象发请求。
+func (d *TelDrive) BatchRemove(ctx context.Context, objs []model.Obj) error {
+	if len(objs) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		telObj, ok := obj.(*Object)
+		if !ok {
+			return fmt.Errorf("无效的对象类型")
+		}
+		ids = append(ids, telObj.ID)
+	}
+
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"ids": ids,
+		}).
+		Delete("/api/files")
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("删除文件失败: %w", &apiError{StatusCode: resp.StatusCode(), Body: resp.String()})
+	}
+
+	return nil
+}
+
+// removeRecursive先尝试直接删除；如果目标是非空文件夹而被服务端拒绝，就先列出子项逐一
+// 删除（子文件夹递归处理），再重试删除这个文件夹本身。
+func (d *TelDrive) removeRecursive(ctx context.Context, obj *Object) error {
+	err := d.BatchRemove(ctx, []model.Obj{obj})
+	if err == nil || !obj.IsFolder || !isNonEmptyFolderErr(err) {
+		return err
+	}
+
+	children, lerr := d.List(ctx, obj, model.ListArgs{})
+	if lerr != nil {
+		return fmt.Errorf("删除非空文件夹失败: %v (列出子项也失败: %v)", err, lerr)
+	}
+
+	for _, child := range children {
+		childObj, ok := child.(*Object)
+		if !ok {
+			continue
+		}
+		if rerr := d.removeRecursive(ctx, childObj); rerr != nil {
+			return rerr
+		}
+	}
+
+	return d.BatchRemove(ctx, []model.Obj{obj})
+}
+
+// isNonEmptyFolderErr判断服务端拒绝删除是不是因为"文件夹非空"，从而决定是否需要递归删除
+// 子项后重试，而不是把所有删除失败都当成需要递归处理。优先按HTTP状态码判断——TelDrive
+// 对不允许的非空目录删除约定返回409 Conflict，这个信号不随服务端语言/版本变化；状态码
+// 不是409的*apiError会再对响应体做英文文案的子串匹配，err不是*apiError（比如网络层错误
+// 被包在别的类型里）时则对err.Error()做同样的匹配作为兜底。
+func isNonEmptyFolderErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusConflict {
+			return true
+		}
+		return containsNonEmptyFolderWording(apiErr.Body)
+	}
+
+	return containsNonEmptyFolderWording(err.Error())
+}
+
+func containsNonEmptyFolderWording(s string) bool {
+	msg := strings.ToLower(s)
+	return strings.Contains(msg, "not empty") || strings.Contains(msg, "non-empty") || strings.Contains(msg, "directory not empty")
+}
+
+// PutURL实现离线下载：把远程URL POST给TelDrive的导入接口，由服务端自己去抓取，
+// 不需要alist中转下载再上传。ImportURLPath留空时视为未配置，返回errs.NotImplement。
+func (d *TelDrive) PutURL(ctx context.Context, dstDir model.Obj, name, url string) (model.Obj, error) {
+	if d.ImportURLPath == "" {
+		return nil, errs.NotImplement
+	}
+
+	dstDirObj, ok := dstDir.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("无效的目标目录类型")
+	}
+
+	parentPath := dstDirObj.Path
+	if parentPath == "/" {
+		parentPath = ""
+	}
+
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"url":      url,
+			"name":     name,
+			"parentId": dstDirObj.ID,
+			"path":     path.Join(parentPath, name),
+		}).
+		Post(d.ImportURLPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("离线下载导入失败: %s", resp.String())
+	}
+
+	var fileInfo FileInfo
+	if err := json.Unmarshal(resp.Body(), &fileInfo); err != nil {
+		return nil, fmt.Errorf("解析离线下载结果失败: %v", err)
+	}
+
+	return &Object{
+		ID:       fileInfo.Id,
+		Name:     name,
+		Size:     fileInfo.Size,
+		Modified: fileInfo.ModTime,
+		IsFolder: false,
+		Path:     path.Join(parentPath, name),
+		ParentID: fileInfo.ParentId,
+		driver:   d,
+	}, nil
+}
@@ -0,0 +1,31 @@
+package teldrive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIsNonEmptyFolderErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"409 conflict apiError", fmt.Errorf("删除文件失败: %w", &apiError{StatusCode: http.StatusConflict, Body: "{}"}), true},
+		{"404 apiError with english wording", fmt.Errorf("删除文件失败: %w", &apiError{StatusCode: http.StatusNotFound, Body: "folder is not empty"}), true},
+		{"404 apiError with unrelated wording", fmt.Errorf("删除文件失败: %w", &apiError{StatusCode: http.StatusNotFound, Body: "no such file"}), false},
+		{"plain error with english wording", errors.New("Directory Not Empty"), true},
+		{"plain error unrelated", errors.New("connection reset"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isNonEmptyFolderErr(c.err); got != c.want {
+				t.Errorf("isNonEmptyFolderErr(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
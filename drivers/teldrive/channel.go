@@ -0,0 +1,176 @@
+package teldrive
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/model"
+	"github.com/AliciaLEO/alist-pro/v3/pkg/utils"
+)
+
+type channelSpec struct {
+	ID     int64
+	Weight int
+}
+
+// channelScheduler在Addition.ChannelID解析出的多个频道之间，按ShardStrategy为每次
+// Put挑选一个目标频道，并记录各频道累计上传字节数供least_used策略和调试端点使用。
+type channelScheduler struct {
+	mu             sync.Mutex
+	channels       []channelSpec
+	rrCounter      int
+	bytesByChannel map[int64]*atomic.Int64
+}
+
+func newChannelScheduler(channels []channelSpec) *channelScheduler {
+	s := &channelScheduler{channels: channels, bytesByChannel: make(map[int64]*atomic.Int64, len(channels))}
+	for _, c := range channels {
+		s.bytesByChannel[c.ID] = &atomic.Int64{}
+	}
+	return s
+}
+
+// parseChannelList 解析逗号分隔的 "channelID[:weight]" 列表，兼容只填一个频道ID的老配置。
+func parseChannelList(raw string) ([]channelSpec, error) {
+	parts := strings.Split(raw, ",")
+	specs := make([]channelSpec, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		idStr, weightStr, hasWeight := strings.Cut(part, ":")
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("频道ID %q 不是合法的数字: %v", idStr, err)
+		}
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("频道 %s 的权重 %q 非法", idStr, weightStr)
+			}
+			weight = w
+		}
+		specs = append(specs, channelSpec{ID: id, Weight: weight})
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("channel_id未配置任何频道")
+	}
+	return specs, nil
+}
+
+// pick按ShardStrategy选出一个频道ID。hash_path下同样的parentPath+fileName总落到同一个
+// 频道上，重试上传时分块也会发往同一个频道。
+func (s *channelScheduler) pick(strategy, parentPath, fileName string) int64 {
+	if len(s.channels) == 1 {
+		return s.channels[0].ID
+	}
+
+	switch strategy {
+	case "hash_path":
+		h := fnv.New64a()
+		h.Write([]byte(parentPath + "/" + fileName))
+		idx := int(h.Sum64() % uint64(len(s.channels)))
+		return s.channels[idx].ID
+
+	case "least_used":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		best := s.channels[0]
+		bestBytes := s.bytesByChannel[best.ID].Load()
+		for _, c := range s.channels[1:] {
+			if b := s.bytesByChannel[c.ID].Load(); b < bestBytes {
+				best, bestBytes = c, b
+			}
+		}
+		return best.ID
+
+	case "weighted":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		total := 0
+		for _, c := range s.channels {
+			total += c.Weight
+		}
+		s.rrCounter = (s.rrCounter + 1) % total
+		acc := 0
+		for _, c := range s.channels {
+			acc += c.Weight
+			if s.rrCounter < acc {
+				return c.ID
+			}
+		}
+		return s.channels[len(s.channels)-1].ID
+
+	default: // round_robin
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c := s.channels[s.rrCounter%len(s.channels)]
+		s.rrCounter++
+		return c.ID
+	}
+}
+
+func (s *channelScheduler) recordUpload(channelID int64, n int64) {
+	s.mu.Lock()
+	counter, ok := s.bytesByChannel[channelID]
+	if !ok {
+		counter = &atomic.Int64{}
+		s.bytesByChannel[channelID] = counter
+	}
+	s.mu.Unlock()
+	counter.Add(n)
+}
+
+func (s *channelScheduler) stats() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int64, len(s.bytesByChannel))
+	for id, counter := range s.bytesByChannel {
+		out[strconv.FormatInt(id, 10)] = counter.Load()
+	}
+	return out
+}
+
+// validateChannels在Init阶段尽量确认配置的频道确实存在：如果服务端提供/api/channels
+// 就拿真实频道列表核对一遍，拿不到的话（老版本TelDrive、网络问题等）就只依赖
+// parseChannelList已经做过的“能解析成int64”这一基本检查，不把Init跑不通的请求当成致命错误。
+func (d *TelDrive) validateChannels(ctx context.Context, channels []channelSpec) {
+	var remote []struct {
+		ID int64 `json:"id"`
+	}
+	resp, err := d.client.R().SetContext(ctx).SetResult(&remote).Get("/api/channels")
+	if err != nil || resp.StatusCode() != 200 || len(remote) == 0 {
+		return
+	}
+
+	known := make(map[int64]bool, len(remote))
+	for _, c := range remote {
+		known[c.ID] = true
+	}
+	for _, c := range channels {
+		if !known[c.ID] {
+			utils.Log.Warnf("teldrive: 配置的频道ID %d 在/api/channels返回结果中未找到", c.ID)
+		}
+	}
+}
+
+// Other实现driver.Other扩展接口，目前只支持method="channel_stats"，用于调试多频道
+// 分片时各频道的累计上传字节数。
+func (d *TelDrive) Other(ctx context.Context, args model.OtherArgs) (interface{}, error) {
+	switch args.Method {
+	case "channel_stats":
+		if d.scheduler == nil {
+			return map[string]int64{}, nil
+		}
+		return d.scheduler.stats(), nil
+	default:
+		return nil, fmt.Errorf("不支持的操作: %s", args.Method)
+	}
+}
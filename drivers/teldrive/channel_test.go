@@ -0,0 +1,106 @@
+package teldrive
+
+import (
+	"testing"
+)
+
+func TestParseChannelList(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		want    []channelSpec
+		wantErr bool
+	}{
+		{"single legacy id", "123", []channelSpec{{ID: 123, Weight: 1}}, false},
+		{"multiple with weights", "123:2, 456:1,789", []channelSpec{
+			{ID: 123, Weight: 2}, {ID: 456, Weight: 1}, {ID: 789, Weight: 1},
+		}, false},
+		{"blank entries ignored", "123,,456", []channelSpec{{ID: 123, Weight: 1}, {ID: 456, Weight: 1}}, false},
+		{"empty", "", nil, true},
+		{"non numeric id", "abc", nil, true},
+		{"non numeric weight", "123:x", nil, true},
+		{"zero weight", "123:0", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseChannelList(c.raw)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseChannelList(%q) = %+v, want error", c.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseChannelList(%q) unexpected error: %v", c.raw, err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("parseChannelList(%q) = %+v, want %+v", c.raw, got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("parseChannelList(%q)[%d] = %+v, want %+v", c.raw, i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChannelSchedulerRoundRobin(t *testing.T) {
+	s := newChannelScheduler([]channelSpec{{ID: 1, Weight: 1}, {ID: 2, Weight: 1}, {ID: 3, Weight: 1}})
+	want := []int64{1, 2, 3, 1, 2, 3}
+	for i, w := range want {
+		if got := s.pick("round_robin", "/p", "f.txt"); got != w {
+			t.Fatalf("pick #%d = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestChannelSchedulerHashPathStable(t *testing.T) {
+	s := newChannelScheduler([]channelSpec{{ID: 1, Weight: 1}, {ID: 2, Weight: 1}, {ID: 3, Weight: 1}})
+	first := s.pick("hash_path", "/movies", "a.mkv")
+	for i := 0; i < 10; i++ {
+		if got := s.pick("hash_path", "/movies", "a.mkv"); got != first {
+			t.Fatalf("hash_path pick #%d = %d, want stable %d", i, got, first)
+		}
+	}
+	// A different path/name is allowed to land on a different channel, but must still be a configured one.
+	other := s.pick("hash_path", "/movies", "b.mkv")
+	found := false
+	for _, c := range s.channels {
+		if c.ID == other {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("hash_path pick %d is not one of the configured channels", other)
+	}
+}
+
+func TestChannelSchedulerLeastUsed(t *testing.T) {
+	s := newChannelScheduler([]channelSpec{{ID: 1, Weight: 1}, {ID: 2, Weight: 1}})
+	s.recordUpload(1, 1000)
+	if got := s.pick("least_used", "/p", "f.txt"); got != 2 {
+		t.Fatalf("least_used pick = %d, want 2 (channel 1 already has bytes recorded)", got)
+	}
+}
+
+func TestChannelSchedulerWeighted(t *testing.T) {
+	s := newChannelScheduler([]channelSpec{{ID: 1, Weight: 3}, {ID: 2, Weight: 1}})
+	counts := map[int64]int{}
+	for i := 0; i < 8; i++ {
+		counts[s.pick("weighted", "/p", "f.txt")]++
+	}
+	if counts[1] != 6 || counts[2] != 2 {
+		t.Fatalf("weighted picks over 2 full cycles = %+v, want {1:6, 2:2}", counts)
+	}
+}
+
+func TestChannelSchedulerSingleChannelShortCircuits(t *testing.T) {
+	s := newChannelScheduler([]channelSpec{{ID: 42, Weight: 1}})
+	for _, strategy := range []string{"round_robin", "weighted", "hash_path", "least_used"} {
+		if got := s.pick(strategy, "/p", "f.txt"); got != 42 {
+			t.Fatalf("pick(%q) with a single channel = %d, want 42", strategy, got)
+		}
+	}
+}
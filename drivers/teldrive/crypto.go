@@ -0,0 +1,336 @@
+package teldrive
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/model"
+	"github.com/AliciaLEO/alist-pro/v3/pkg/http_range"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 客户端E2EE方案：文件在离开alist之前就已经被加密，TelDrive及其原生encrypt_files
+// 对这份密文一无所知。每个文件都以一个自描述头开始，固定64KiB一帧，
+// 每帧是 [nonce(12)|ciphertext|tag(16)]，帧之间互相独立，因此代理模式下可以
+// 按帧对齐做Range请求而不必解密整个文件。
+const (
+	e2eMagic     = "TDE1"
+	e2eFrameSize = 64 * 1024
+	e2eSaltSize  = 16
+	e2eNonceSize = 12
+	e2eTagSize   = 16
+)
+
+// scrypt参数，按请求固定为 N=32768, r=8, p=1，对应32字节密钥。
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// e2eHeaderLen 是文件头 "TDE1|<saltHex>|<frameSize十进制，10位补零>|<origSize十进制，20位补零>\n"
+// 的固定长度，所有字段都是定宽编码，因此头部长度与内容无关，可以提前算出来。
+var e2eHeaderLen = len(formatE2EHeader(make([]byte, e2eSaltSize), e2eFrameSize, 0))
+
+func formatE2EHeader(salt []byte, frameSize int, origSize int64) string {
+	return fmt.Sprintf("%s|%s|%010d|%020d\n", e2eMagic, hex.EncodeToString(salt), frameSize, origSize)
+}
+
+func parseE2EHeader(header []byte) (salt []byte, frameSize int, origSize int64, ok bool) {
+	if len(header) != e2eHeaderLen {
+		return nil, 0, 0, false
+	}
+	var saltHex string
+	var fs int
+	var origin int64
+	n, err := fmt.Sscanf(string(header), e2eMagic+"|%32s|%d|%d\n", &saltHex, &fs, &origin)
+	if err != nil || n != 3 {
+		return nil, 0, 0, false
+	}
+	salt, err = hex.DecodeString(saltHex)
+	if err != nil || len(salt) != e2eSaltSize {
+		return nil, 0, 0, false
+	}
+	return salt, fs, origin, true
+}
+
+// deriveE2EKey 用口令和盐派生出AES-256所需的32字节密钥。目前只支持scrypt，
+// kdf为空时按scrypt处理，与Addition.E2EKDF的默认值保持一致；其他未识别的kdf名称返回错误。
+func deriveE2EKey(passphrase string, salt []byte, kdf string) ([]byte, error) {
+	switch kdf {
+	case "", "scrypt":
+		return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	default:
+		return nil, fmt.Errorf("不支持的E2E KDF: %s", kdf)
+	}
+}
+
+// e2eCipherSize 计算origSize大小的明文整体加密（含头部）后的密文总大小，这样Put
+// 不需要真正跑一遍加密就能知道上传给TelDrive的字节数。
+func e2eCipherSize(origSize int64) int64 {
+	numFrames := int64(0)
+	if origSize > 0 {
+		numFrames = (origSize + e2eFrameSize - 1) / e2eFrameSize
+	}
+	return int64(e2eHeaderLen) + origSize + numFrames*(e2eNonceSize+e2eTagSize)
+}
+
+// e2eEncryptReader 把明文流包装成自描述的加密流：先吐出头部，再逐帧加密。
+type e2eEncryptReader struct {
+	src      io.Reader
+	gcm      cipher.AEAD
+	buf      []byte
+	pos      int
+	finished bool
+}
+
+// deriveE2ESalt从passphrase+uploadID确定性地派生盐，而不是像早期实现那样每次Put调用都用
+// crypto/rand随机生成。断点续传（upload.go的existingChunks）以uploadID为键跳过已提交的分块，
+// 如果salt每次都不同，续传时会用新salt派生的新key加密剩余分块，而已提交分块（含嵌有旧salt的
+// 文件头）保持不变，最终文件头指向的salt和尾部分块实际使用的key对不上——decryptE2ERangeReader
+// 在第一个分块之后的每一帧都会GCM认证失败，且这个错误在上传阶段完全不会被发现。同一个
+// (passphrase, uploadID)组合确定性地得到同一个盐，使同一次上传的所有重试/跨进程续传
+// 都派生出同一把密钥。
+func deriveE2ESalt(passphrase, uploadID string) []byte {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+	mac.Write([]byte(uploadID))
+	return mac.Sum(nil)[:e2eSaltSize]
+}
+
+func newE2EEncryptReader(src io.Reader, passphrase, kdf, uploadID string) (io.Reader, []byte, error) {
+	salt := deriveE2ESalt(passphrase, uploadID)
+	key, err := deriveE2EKey(passphrase, salt, kdf)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &e2eEncryptReader{src: src, gcm: gcm, buf: nil}, salt, nil
+}
+
+func (r *e2eEncryptReader) Read(p []byte) (int, error) {
+	for r.pos >= len(r.buf) {
+		if r.finished {
+			return 0, io.EOF
+		}
+		if err := r.fillNextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+func (r *e2eEncryptReader) fillNextFrame() error {
+	plain := make([]byte, e2eFrameSize)
+	n, err := io.ReadFull(r.src, plain)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if n == 0 {
+		r.finished = true
+		return nil
+	}
+
+	nonce := make([]byte, e2eNonceSize)
+	if _, rerr := rand.Read(nonce); rerr != nil {
+		return fmt.Errorf("生成分帧nonce失败: %v", rerr)
+	}
+	ciphertext := r.gcm.Seal(nil, nonce, plain[:n], nil)
+
+	frame := make([]byte, 0, e2eNonceSize+len(ciphertext))
+	frame = append(frame, nonce...)
+	frame = append(frame, ciphertext...)
+
+	r.buf = frame
+	r.pos = 0
+
+	if n < e2eFrameSize {
+		// 不是完整一帧，说明源已经读到头了，下一次Read直接返回EOF
+		r.finished = true
+	}
+	return nil
+}
+
+// wrapE2EUploadReader 在Put上传之前把明文流替换成自描述的加密流，并返回密文
+// 的总大小（用于分块计算与CreateFileRequest.Size），header内联在数据里，
+// 因此重命名/移动文件都不会丢失解密所需的信息。uploadID与runUploadPipeline使用的是
+// 同一个值，使盐的派生与断点续传状态共享同一个键。
+func wrapE2EUploadReader(src io.Reader, fileSize int64, passphrase, kdf, uploadID string) (io.Reader, int64, error) {
+	enc, salt, err := newE2EEncryptReader(src, passphrase, kdf, uploadID)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := []byte(formatE2EHeader(salt, e2eFrameSize, fileSize))
+	return io.MultiReader(bytesReader(header), enc), e2eCipherSize(fileSize), nil
+}
+
+// bytesReader避免为了一次头部拼接而引入bytes包之外的新依赖。
+func bytesReader(b []byte) io.Reader {
+	return &sliceReader{data: b}
+}
+
+type sliceReader struct {
+	data []byte
+	pos  int
+}
+
+func (s *sliceReader) Read(p []byte) (int, error) {
+	if s.pos >= len(s.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+// decryptE2ERangeReader 在proxy/stream模式下，把对明文字节区间的请求翻译成对齐帧边界
+// 的密文字节区间请求，取回密文后逐帧解密，再裁掉请求范围之外多取的部分。
+// 如果header的salt/frameSize解不出来，说明这不是一个E2E文件，调用方应退回普通代理。
+func (d *TelDrive) decryptE2ERangeReader(ctx context.Context, obj *Object, salt []byte, frameSize int, origSize int64, plainRange http_range.Range) (io.ReadCloser, error) {
+	key, err := deriveE2EKey(d.E2EPassphrase, salt, d.E2EKDF)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	start := plainRange.Start
+	length := plainRange.Length
+	if length < 0 {
+		length = origSize - start
+	}
+	end := start + length // 明文结束偏移（不含）
+
+	firstFrame := start / int64(frameSize)
+	lastFrame := (end - 1) / int64(frameSize)
+	frameStride := int64(e2eNonceSize + frameSize + e2eTagSize)
+
+	cipherStart := int64(e2eHeaderLen) + firstFrame*frameStride
+	cipherEnd := int64(e2eHeaderLen) + (lastFrame+1)*frameStride - 1
+
+	rawBody, err := d.rawObjectRange(ctx, obj, cipherStart, cipherEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rawBody.Close()
+
+	raw, err := io.ReadAll(rawBody)
+	if err != nil {
+		return nil, fmt.Errorf("读取E2E密文失败: %v", err)
+	}
+
+	var plain []byte
+	offset := int64(0)
+	for frameNo := firstFrame; frameNo <= lastFrame; frameNo++ {
+		frameCipherLen := frameStride
+		if (frameNo+1)*int64(frameSize) > origSize {
+			lastPlain := origSize - frameNo*int64(frameSize)
+			frameCipherLen = int64(e2eNonceSize+e2eTagSize) + lastPlain
+		}
+		if offset+frameCipherLen > int64(len(raw)) {
+			frameCipherLen = int64(len(raw)) - offset
+		}
+		if frameCipherLen < e2eNonceSize+e2eTagSize {
+			break
+		}
+		frame := raw[offset : offset+frameCipherLen]
+		offset += frameCipherLen
+
+		nonce := frame[:e2eNonceSize]
+		ciphertext := frame[e2eNonceSize:]
+		framePlain, derr := gcm.Open(nil, nonce, ciphertext, nil)
+		if derr != nil {
+			return nil, fmt.Errorf("E2E解密失败，口令可能不正确: %v", derr)
+		}
+		plain = append(plain, framePlain...)
+	}
+
+	trimFront := start - firstFrame*int64(frameSize)
+	trimLen := end - start
+	if trimFront+trimLen > int64(len(plain)) {
+		trimLen = int64(len(plain)) - trimFront
+	}
+	if trimFront < 0 || trimFront > int64(len(plain)) {
+		return nil, fmt.Errorf("E2E range越界")
+	}
+
+	return io.NopCloser(bytesReader(plain[trimFront : trimFront+trimLen])), nil
+}
+
+// restoreE2ESizes把List返回结果中Size字段从TelDrive记录的密文大小改写成文件头里的
+// 明文大小，这样列表/WebDAV等看到的大小才会跟e2eLink实际能读出的字节数一致，而不是
+// 带着头部+逐帧nonce/tag开销的密文体积。并发度复用UploadConcurrency，跟fillThumbnails
+// 一样不再为这一个边角场景单独加配置项。
+func (d *TelDrive) restoreE2ESizes(ctx context.Context, files []model.Obj) {
+	concurrency := d.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, f := range files {
+		obj, ok := f.(*Object)
+		if !ok || obj.IsFolder {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(obj *Object) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.restoreE2ESize(ctx, obj)
+		}(obj)
+	}
+
+	wg.Wait()
+}
+
+// restoreE2ESize只取文件头对应的那一段字节来判断并解码，不是E2E文件（比如启用E2E之前
+// 上传的旧文件）时header解不出来，直接保留TelDrive返回的原始大小。
+func (d *TelDrive) restoreE2ESize(ctx context.Context, obj *Object) {
+	if obj.Size < int64(e2eHeaderLen) {
+		return
+	}
+
+	body, err := d.rawObjectRange(ctx, obj, 0, int64(e2eHeaderLen)-1)
+	if err != nil {
+		return
+	}
+	header, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return
+	}
+
+	_, _, origSize, ok := parseE2EHeader(header)
+	if !ok {
+		return
+	}
+	obj.Size = origSize
+}
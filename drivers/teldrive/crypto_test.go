@@ -0,0 +1,150 @@
+package teldrive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestE2EHeaderRoundTrip(t *testing.T) {
+	salt := make([]byte, e2eSaltSize)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	header := formatE2EHeader(salt, e2eFrameSize, 1234567)
+	if len(header) != e2eHeaderLen {
+		t.Fatalf("formatE2EHeader produced %d bytes, want e2eHeaderLen=%d", len(header), e2eHeaderLen)
+	}
+
+	gotSalt, frameSize, origSize, ok := parseE2EHeader([]byte(header))
+	if !ok {
+		t.Fatal("parseE2EHeader failed to parse a header produced by formatE2EHeader")
+	}
+	if !bytes.Equal(gotSalt, salt) {
+		t.Errorf("parseE2EHeader salt = %x, want %x", gotSalt, salt)
+	}
+	if frameSize != e2eFrameSize {
+		t.Errorf("parseE2EHeader frameSize = %d, want %d", frameSize, e2eFrameSize)
+	}
+	if origSize != 1234567 {
+		t.Errorf("parseE2EHeader origSize = %d, want %d", origSize, 1234567)
+	}
+}
+
+func TestParseE2EHeaderRejectsGarbage(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		[]byte("not a header at all"),
+		[]byte(formatE2EHeader(make([]byte, e2eSaltSize), e2eFrameSize, 1))[:e2eHeaderLen-1],
+	}
+	for _, c := range cases {
+		if _, _, _, ok := parseE2EHeader(c); ok {
+			t.Errorf("parseE2EHeader(%q) = ok, want failure", c)
+		}
+	}
+}
+
+func TestE2ECipherSize(t *testing.T) {
+	cases := []struct {
+		origSize int64
+	}{
+		{0}, {1}, {e2eFrameSize - 1}, {e2eFrameSize}, {e2eFrameSize + 1}, {3*e2eFrameSize + 100},
+	}
+	for _, c := range cases {
+		got := e2eCipherSize(c.origSize)
+		numFrames := int64(0)
+		if c.origSize > 0 {
+			numFrames = (c.origSize + e2eFrameSize - 1) / e2eFrameSize
+		}
+		want := int64(e2eHeaderLen) + c.origSize + numFrames*(e2eNonceSize+e2eTagSize)
+		if got != want {
+			t.Errorf("e2eCipherSize(%d) = %d, want %d", c.origSize, got, want)
+		}
+	}
+}
+
+func TestE2EEncryptReaderMatchesCipherSize(t *testing.T) {
+	plain := bytes.Repeat([]byte("a"), e2eFrameSize*2+123)
+
+	encReader, cipherSize, err := wrapE2EUploadReader(bytes.NewReader(plain), int64(len(plain)), "s3cr3t", "scrypt", "upload-1")
+	if err != nil {
+		t.Fatalf("wrapE2EUploadReader: %v", err)
+	}
+
+	out, err := io.ReadAll(encReader)
+	if err != nil {
+		t.Fatalf("reading encrypted stream: %v", err)
+	}
+	if int64(len(out)) != cipherSize {
+		t.Fatalf("encrypted stream length = %d, want cipherSize = %d", len(out), cipherSize)
+	}
+
+	salt, frameSize, origSize, ok := parseE2EHeader(out[:e2eHeaderLen])
+	if !ok {
+		t.Fatal("parseE2EHeader failed on header emitted by wrapE2EUploadReader")
+	}
+	if len(salt) != e2eSaltSize {
+		t.Errorf("salt length = %d, want %d", len(salt), e2eSaltSize)
+	}
+	if frameSize != e2eFrameSize {
+		t.Errorf("frameSize = %d, want %d", frameSize, e2eFrameSize)
+	}
+	if origSize != int64(len(plain)) {
+		t.Errorf("origSize = %d, want %d", origSize, len(plain))
+	}
+}
+
+func TestDeriveE2ESaltDeterministicPerUpload(t *testing.T) {
+	saltA1 := deriveE2ESalt("s3cr3t", "upload-a")
+	saltA2 := deriveE2ESalt("s3cr3t", "upload-a")
+	if !bytes.Equal(saltA1, saltA2) {
+		t.Fatalf("deriveE2ESalt is not deterministic: %x != %x", saltA1, saltA2)
+	}
+	if len(saltA1) != e2eSaltSize {
+		t.Fatalf("deriveE2ESalt length = %d, want %d", len(saltA1), e2eSaltSize)
+	}
+
+	saltB := deriveE2ESalt("s3cr3t", "upload-b")
+	if bytes.Equal(saltA1, saltB) {
+		t.Fatal("deriveE2ESalt produced the same salt for two different uploadIDs")
+	}
+}
+
+// TestE2EResumeReusesSameKey模拟一次E2E上传在第一个分块提交之后中断、并在"另一次"Put调用
+// （同一个uploadID，比如跨进程续传）中重新加密剩余内容：两次调用各自独立生成的加密流，
+// 文件头里的salt必须一致，否则已提交分块记着的旧salt和新分块实际使用的key就会对不上。
+func TestE2EResumeReusesSameKey(t *testing.T) {
+	plain := bytes.Repeat([]byte("b"), e2eFrameSize+42)
+	uploadID := "resume-upload-id"
+
+	first, _, err := wrapE2EUploadReader(bytes.NewReader(plain), int64(len(plain)), "pw", "scrypt", uploadID)
+	if err != nil {
+		t.Fatalf("first wrapE2EUploadReader: %v", err)
+	}
+	firstOut, err := io.ReadAll(first)
+	if err != nil {
+		t.Fatalf("reading first encrypted stream: %v", err)
+	}
+
+	second, _, err := wrapE2EUploadReader(bytes.NewReader(plain), int64(len(plain)), "pw", "scrypt", uploadID)
+	if err != nil {
+		t.Fatalf("second wrapE2EUploadReader: %v", err)
+	}
+	secondOut, err := io.ReadAll(second)
+	if err != nil {
+		t.Fatalf("reading second encrypted stream: %v", err)
+	}
+
+	firstSalt, _, _, ok := parseE2EHeader(firstOut[:e2eHeaderLen])
+	if !ok {
+		t.Fatal("parseE2EHeader failed on first stream's header")
+	}
+	secondSalt, _, _, ok := parseE2EHeader(secondOut[:e2eHeaderLen])
+	if !ok {
+		t.Fatal("parseE2EHeader failed on second stream's header")
+	}
+	if !bytes.Equal(firstSalt, secondSalt) {
+		t.Fatalf("salt differs across resumed invocations for the same uploadID: %x != %x", firstSalt, secondSalt)
+	}
+}
@@ -8,38 +8,47 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"path"
-	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/AliciaLEO/alist-pro/v3/internal/driver"
 	"github.com/AliciaLEO/alist-pro/v3/internal/errs"
 	"github.com/AliciaLEO/alist-pro/v3/internal/model"
-	"github.com/AliciaLEO/alist-pro/v3/pkg/utils"
 	"github.com/go-resty/resty/v2"
-	"github.com/google/uuid"
 )
 
 type TelDrive struct {
 	model.Storage
 	Addition
 
-	client *resty.Client
-	userId int64
+	client     *resty.Client
+	userId     int64
+	scheduler  *channelScheduler
+	linkCache  *linkCacheStore
+	thumbCache *thumbnailLRU
 }
 
 type Addition struct {
 	AccessToken      string `json:"access_token" required:"true" help:"TelDrive访问令牌Cookie"`
 	ApiHost          string `json:"api_host" required:"true" help:"TelDrive API主机地址"`
 	UploadHost       string `json:"upload_host" help:"TelDrive上传API主机地址（可选）"`
-	ChannelID        string `json:"channel_id" required:"true" help:"TelDrive频道ID"`
+	ChannelID        string `json:"channel_id" required:"true" help:"TelDrive频道ID，支持逗号分隔的多个频道，格式为channelID[:weight]，如 123,456:2"`
+	ShardStrategy    string `json:"shard_strategy" type:"select" options:"round_robin,weighted,hash_path,least_used" default:"round_robin" help:"配置多个频道时，上传如何在频道间分摊"`
 	ChunkSize        int64  `json:"chunk_size" default:"500" help:"分块大小(MB)，默认500MB"`
 	RandomChunkName  bool   `json:"random_chunk_name" default:"true" help:"使用随机分块名称增强安全性"`
 	EncryptFiles     bool   `json:"encrypt_files" default:"false" help:"启用TelDrive原生加密"`
 	UploadConcurrency int    `json:"upload_concurrency" default:"4" help:"上传并发数"`
+	MaxUploadRetries int    `json:"max_upload_retries" default:"3" help:"单个分块上传失败后的最大重试次数"`
+	DownloadMode     string `json:"download_mode" type:"select" options:"redirect,proxy,stream" default:"redirect" help:"redirect直接302跳转；proxy/stream由alist代理转发，兼容不携带access_token的客户端"`
+	SpeedLimit       int    `json:"speed_limit" default:"0" help:"代理下载限速(KB/s)，0表示不限速，仅在proxy/stream模式下生效"`
+	LinkCacheTTL     int    `json:"link_cache_ttl" default:"3600" help:"redirect模式下载链接的内存缓存时间(秒)"`
+	E2EEnabled       bool   `json:"e2e_enabled" default:"false" help:"启用客户端AES-GCM加密，文件在发往Telegram前就已加密，独立于服务端encrypt_files"`
+	E2EPassphrase    string `json:"e2e_passphrase" help:"端到端加密口令，留空则无法启用e2e_enabled"`
+	E2EKDF           string `json:"e2e_kdf" type:"select" options:"scrypt" default:"scrypt" help:"口令派生密钥的KDF算法"`
+	ImportURLPath    string `json:"import_url_path" help:"离线下载（导入远程URL）接口路径，留空则不支持离线下载"`
+	EnableThumbnails bool   `json:"enable_thumbnails" default:"false" help:"为图片/视频列出缩略图，会增加List时的API请求量"`
+	ThumbnailSize    string `json:"thumbnail_size" type:"select" options:"small,medium" default:"small" help:"缩略图尺寸"`
+	ThumbnailCacheTTL int   `json:"thumbnail_cache_ttl" default:"1800" help:"缩略图内存缓存时间(秒)"`
 }
 
 type FileInfo struct {
@@ -148,6 +157,15 @@ func (d *TelDrive) Init(ctx context.Context) error {
 
 	d.userId = session.UserId
 
+	channels, err := parseChannelList(d.ChannelID)
+	if err != nil {
+		return fmt.Errorf("channel_id配置有误: %v", err)
+	}
+	d.validateChannels(ctx, channels)
+	d.scheduler = newChannelScheduler(channels)
+	d.linkCache = newLinkCacheStore()
+	d.thumbCache = newThumbnailLRU()
+
 	return nil
 }
 
@@ -184,15 +202,24 @@ func (d *TelDrive) List(ctx context.Context, dir model.Obj, args model.ListArgs)
 			ID:       file.Id,
 			Name:     file.Name,
 			Size:     file.Size,
-			ModTime:  file.ModTime,
+			Modified: file.ModTime,
 			IsFolder: file.Type == "folder",
 			Path:     path + "/" + file.Name,
 			ParentID: file.ParentId,
+			MimeType: file.MimeType,
 			driver:   d,
 		}
 		files = append(files, obj)
 	}
 
+	if d.E2EEnabled {
+		d.restoreE2ESizes(ctx, files)
+	}
+
+	if d.EnableThumbnails {
+		d.fillThumbnails(ctx, files)
+	}
+
 	return files, nil
 }
 
@@ -206,7 +233,25 @@ func (d *TelDrive) Link(ctx context.Context, file model.Obj, args model.LinkArgs
 		return nil, errs.NotFile
 	}
 
-	var downloadURL string
+	if d.E2EEnabled {
+		// E2E文件必须经过alist解密才能读出明文，不能让客户端直接跟着302走
+		return d.e2eLink(obj)
+	}
+
+	if d.DownloadMode == "proxy" || d.DownloadMode == "stream" {
+		return d.proxyLink(obj)
+	}
+
+	return d.redirectLink(obj)
+}
+
+// redirectLink 保持原有行为：向TelDrive换取一次性重定向地址并做内存缓存，
+// 在LinkCacheTTL到期前命中同一文件不必重复请求。
+func (d *TelDrive) redirectLink(obj *Object) (*model.Link, error) {
+	if cached, ok := d.linkCache.get(obj.ID); ok {
+		return cached, nil
+	}
+
 	resp, err := d.client.R().
 		SetQueryParam("id", obj.ID).
 		Get("/api/files/download")
@@ -219,17 +264,20 @@ func (d *TelDrive) Link(ctx context.Context, file model.Obj, args model.LinkArgs
 		return nil, fmt.Errorf("获取下载链接失败: %s", resp.String())
 	}
 
-	downloadURL = resp.Header().Get("Location")
+	downloadURL := resp.Header().Get("Location")
 	if downloadURL == "" {
 		return nil, fmt.Errorf("获取下载链接失败: 未找到重定向URL")
 	}
 
-	return &model.Link{
+	link := &model.Link{
 		URL: downloadURL,
 		Header: http.Header{
 			"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36"},
 		},
-	}, nil
+	}
+
+	d.linkCache.set(obj.ID, link, d.LinkCacheTTL)
+	return link, nil
 }
 
 func (d *TelDrive) MakeDir(ctx context.Context, parentDir model.Obj, dirName string) (model.Obj, error) {
@@ -265,7 +313,7 @@ func (d *TelDrive) MakeDir(ctx context.Context, parentDir model.Obj, dirName str
 		ID:       fileInfo.Id,
 		Name:     dirName,
 		Size:     0,
-		ModTime:  fileInfo.ModTime,
+		Modified: fileInfo.ModTime,
 		IsFolder: true,
 		Path:     newPath,
 		ParentID: fileInfo.ParentId,
@@ -286,104 +334,36 @@ func (d *TelDrive) Put(ctx context.Context, dstDir model.Obj, file model.FileStr
 		return nil, fmt.Errorf("不支持未知大小的文件上传")
 	}
 
-	// 准备上传信息
-	channelID, _ := strconv.ParseInt(d.ChannelID, 10, 64)
+	// 按ShardStrategy从已配置的频道里选一个作为本次上传的目标频道
+	channelID := d.scheduler.pick(d.ShardStrategy, parentPath, fileName)
 	chunkSize := d.ChunkSize * 1024 * 1024 // 转换为字节
-	totalChunks := (fileSize + chunkSize - 1) / chunkSize
 
-	// 生成上传ID
+	// 生成上传ID，同一文件重复上传会复用同一个ID，从而命中断点续传状态
 	uploadID := getMD5Hash(fmt.Sprintf("%s:%s:%d:%d", parentPath, fileName, fileSize, d.userId))
 
-	// 检查是否有已存在的分块
-	var existingChunks map[int]PartFile = make(map[int]PartFile)
-	resp, err := d.client.R().Get("/api/uploads/" + uploadID)
-	if err == nil && resp.StatusCode() == 200 {
-		var parts []PartFile
-		err = json.Unmarshal(resp.Body(), &parts)
-		if err == nil {
-			for _, part := range parts {
-				existingChunks[part.PartNo] = part
-			}
-		}
-	}
-
-	// 上传文件分块
-	var partsToCommit []PartFile
-	var uploadedSize int64
-
-	for chunkNo := 1; chunkNo <= int(totalChunks); chunkNo++ {
-		if existing, ok := existingChunks[chunkNo]; ok {
-			// 跳过已上传的分块
-			io.CopyN(io.Discard, file, existing.Size)
-			partsToCommit = append(partsToCommit, existing)
-			uploadedSize += existing.Size
-			up(float64(uploadedSize) / float64(fileSize) * 100)
-			continue
-		}
-
-		n := chunkSize
-		if chunkNo == int(totalChunks) {
-			n = fileSize - uploadedSize
-		}
-
-		chunkName := fileName
-		if d.RandomChunkName {
-			chunkName = getMD5Hash(uuid.New().String())
-		} else if totalChunks > 1 {
-			chunkName = fmt.Sprintf("%s.part.%03d", fileName, chunkNo)
-		}
-
-		partReader := io.LimitReader(file, n)
-
-		// 构建上传URL
-		uploadURL := d.ApiHost + "/api/uploads/" + uploadID
-		if d.UploadHost != "" {
-			uploadURL = d.UploadHost + "/api/uploads/" + uploadID
-		}
-
-		// 构建查询参数
-		params := url.Values{}
-		params.Add("partName", chunkName)
-		params.Add("fileName", fileName)
-		params.Add("partNo", strconv.Itoa(chunkNo))
-		params.Add("channelId", d.ChannelID)
-		params.Add("encrypted", strconv.FormatBool(d.EncryptFiles))
-
-		// 上传分块
-		resp, err := d.client.R().
-			SetQueryParamsFromValues(params).
-			SetHeader("Content-Type", "application/octet-stream").
-			SetBody(partReader).
-			Post(uploadURL)
-
-		if err != nil {
-			return nil, fmt.Errorf("上传分块失败: %v", err)
-		}
-
-		if resp.StatusCode() != 200 {
-			return nil, fmt.Errorf("上传分块失败: %s", resp.String())
+	// 当启用E2E时，真正发往TelDrive的是自描述的密文流，体积比原始文件大一点
+	// （每64KiB一帧的nonce+tag开销），上传大小和分块计划都要按密文大小来算
+	var uploadReader io.Reader = file
+	uploadSize := fileSize
+	if d.E2EEnabled {
+		if d.E2EPassphrase == "" {
+			return nil, fmt.Errorf("启用了e2e_enabled但未配置e2e_passphrase")
 		}
-
-		// 解析分块信息
-		var partInfo PartFile
-		err = json.Unmarshal(resp.Body(), &partInfo)
+		encReader, cipherSize, err := wrapE2EUploadReader(file, fileSize, d.E2EPassphrase, d.E2EKDF, uploadID)
 		if err != nil {
-			return nil, fmt.Errorf("解析分块信息失败: %v", err)
-		}
-
-		if partInfo.PartId == 0 {
-			return nil, fmt.Errorf("上传分块失败: 未获取到分块ID")
+			return nil, err
 		}
-
-		uploadedSize += n
-		partsToCommit = append(partsToCommit, partInfo)
-		up(float64(uploadedSize) / float64(fileSize) * 100)
+		uploadReader = encReader
+		uploadSize = cipherSize
 	}
 
-	// 按分块序号排序
-	sort.Slice(partsToCommit, func(i, j int) bool {
-		return partsToCommit[i].PartNo < partsToCommit[j].PartNo
-	})
+	// 分块经由并行上传流水线处理：落盘切分、UploadConcurrency个worker并发上传、
+	// 每个分块都带指数退避重试，并把完成进度落到断点续传状态文件里
+	partsToCommit, err := d.runUploadPipeline(ctx, uploadID, channelID, uploadReader, fileName, uploadSize, chunkSize, up)
+	if err != nil {
+		return nil, err
+	}
+	d.scheduler.recordUpload(channelID, uploadSize)
 
 	// 准备文件分块信息
 	fileChunks := []FilePart{}
@@ -396,14 +376,14 @@ func (d *TelDrive) Put(ctx context.Context, dstDir model.Obj, file model.FileStr
 		Name:      fileName,
 		Type:      "file",
 		Path:      path.Join(parentPath, fileName),
-		Size:      fileSize,
+		Size:      uploadSize,
 		ChannelID: channelID,
 		Encrypted: d.EncryptFiles,
 		Parts:     fileChunks,
 		ModTime:   file.ModTime(),
 	}
 
-	resp, err = d.client.R().
+	resp, err := d.client.R().
 		SetBody(createFileReq).
 		Post("/api/files")
 
@@ -426,7 +406,7 @@ func (d *TelDrive) Put(ctx context.Context, dstDir model.Obj, file model.FileStr
 		ID:       fileInfo.Id,
 		Name:     fileName,
 		Size:     fileSize,
-		ModTime:  fileInfo.ModTime,
+		Modified: fileInfo.ModTime,
 		IsFolder: false,
 		Path:     path.Join(parentPath, fileName),
 		ParentID: fileInfo.ParentId,
@@ -440,21 +420,7 @@ func (d *TelDrive) Remove(ctx context.Context, obj model.Obj) error {
 		return fmt.Errorf("无效的对象类型")
 	}
 
-	resp, err := d.client.R().
-		SetBody(map[string]interface{}{
-			"ids": []string{telObj.ID},
-		}).
-		Delete("/api/files")
-
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("删除文件失败: %s", resp.String())
-	}
-
-	return nil
+	return d.removeRecursive(ctx, telObj)
 }
 
 func (d *TelDrive) Rename(ctx context.Context, srcObj model.Obj, newName string) (model.Obj, error) {
@@ -519,4 +485,6 @@ func (d *TelDrive) Move(ctx context.Context, srcObj, dstDir model.Obj) (model.Ob
 	return &newObj, nil
 }
 
-var _ driver.Driver = (*TelDrive)(nil)
\ No newline at end of file
+var _ driver.Driver = (*TelDrive)(nil)
+var _ driver.PutURL = (*TelDrive)(nil)
+var _ driver.Other = (*TelDrive)(nil)
\ No newline at end of file
@@ -0,0 +1,211 @@
+package teldrive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/model"
+	"github.com/AliciaLEO/alist-pro/v3/pkg/http_range"
+)
+
+// linkCache 以文件ID为键缓存已经换取到的重定向地址，在LinkCacheTTL到期前
+// 复用同一个链接，避免每次播放/刷新都去敲一遍TelDrive的换链接口。
+// 缓存挂在每个*TelDrive实例上而不是包级变量：不同存储挂载各自独立的account/api_host，
+// 文件ID的命名空间互不相通，共享一份包级缓存会让挂载A读到挂载B换出的下载链接。
+type linkCacheStore struct {
+	mu    sync.Mutex
+	items map[string]cachedLink
+}
+
+func newLinkCacheStore() *linkCacheStore {
+	return &linkCacheStore{items: make(map[string]cachedLink)}
+}
+
+type cachedLink struct {
+	link    *model.Link
+	expires time.Time
+}
+
+func (c *linkCacheStore) get(id string) (*model.Link, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.items[id]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.link, true
+}
+
+func (c *linkCacheStore) set(id string, link *model.Link, ttlSeconds int) {
+	if ttlSeconds <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[id] = cachedLink{link: link, expires: time.Now().Add(time.Duration(ttlSeconds) * time.Second)}
+}
+
+// rawObjectRange 对/api/files/{id}/stream发起一次带认证的Range请求，返回原始（可能是
+// 密文）字节流，proxy/stream的直通转发和E2E解密都基于这同一个helper。end<0表示开区间
+// "bytes=start-"，即读到EOF为止。
+func (d *TelDrive) rawObjectRange(ctx context.Context, obj *Object, start, end int64) (io.ReadCloser, error) {
+	req := d.client.R().SetContext(ctx).SetDoNotParseResponse(true).
+		SetQueryParam("id", obj.ID)
+
+	if end < 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", start))
+	} else {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+
+	resp, err := req.Get("/api/files/" + obj.ID + "/stream")
+	if err != nil {
+		return nil, err
+	}
+
+	rawResp := resp.RawResponse
+	if rawResp.StatusCode != http.StatusOK && rawResp.StatusCode != http.StatusPartialContent {
+		rawResp.Body.Close()
+		return nil, fmt.Errorf("获取代理下载数据失败: status %d", rawResp.StatusCode)
+	}
+	return rawResp.Body, nil
+}
+
+func (d *TelDrive) speedLimited(body io.ReadCloser) io.ReadCloser {
+	if d.SpeedLimit <= 0 {
+		return body
+	}
+	return &rateLimitedReadCloser{ReadCloser: body, bucket: newTokenBucket(int64(d.SpeedLimit) * 1024)}
+}
+
+// proxyLink 返回一个携带RangeReader的model.Link，由alist自己用带Range头的认证请求
+// 向TelDrive取数据再转发给客户端，而不是让客户端直接跟随302——这样不继承
+// access_token cookie的播放器、WebDAV挂载等客户端也能正常工作。
+func (d *TelDrive) proxyLink(obj *Object) (*model.Link, error) {
+	return &model.Link{
+		RangeReader: func(ctx context.Context, httpRange http_range.Range) (io.ReadCloser, error) {
+			end := int64(-1)
+			if httpRange.Length >= 0 {
+				end = httpRange.Start + httpRange.Length - 1
+			}
+			body, err := d.rawObjectRange(ctx, obj, httpRange.Start, end)
+			if err != nil {
+				return nil, err
+			}
+			return d.speedLimited(body), nil
+		},
+	}, nil
+}
+
+// e2eLink强制走代理模式，每次RangeReader被调用读取数据时才去嗅探文件头是否是E2E方案写入的，
+// 这样未加密的历史文件仍按原始字节透传；不论文件是否为E2E，每次读取都会先多发一次头部请求。
+func (d *TelDrive) e2eLink(obj *Object) (*model.Link, error) {
+	return &model.Link{
+		RangeReader: func(ctx context.Context, httpRange http_range.Range) (io.ReadCloser, error) {
+			headerBody, err := d.rawObjectRange(ctx, obj, 0, int64(e2eHeaderLen)-1)
+			if err != nil {
+				return nil, err
+			}
+			header, err := io.ReadAll(headerBody)
+			headerBody.Close()
+			if err != nil {
+				return nil, fmt.Errorf("读取E2E文件头失败: %v", err)
+			}
+
+			salt, frameSize, origSize, ok := parseE2EHeader(header)
+			if !ok {
+				// 不是E2E文件，按普通代理透传原始请求的范围
+				end := int64(-1)
+				if httpRange.Length >= 0 {
+					end = httpRange.Start + httpRange.Length - 1
+				}
+				body, err := d.rawObjectRange(ctx, obj, httpRange.Start, end)
+				if err != nil {
+					return nil, err
+				}
+				return d.speedLimited(body), nil
+			}
+
+			if d.E2EPassphrase == "" {
+				return nil, fmt.Errorf("文件已使用E2E加密，但当前未配置e2e_passphrase")
+			}
+
+			plain, err := d.decryptE2ERangeReader(ctx, obj, salt, frameSize, origSize, httpRange)
+			if err != nil {
+				return nil, err
+			}
+			return d.speedLimited(plain), nil
+		},
+	}, nil
+}
+
+// tokenBucket 是一个简单的令牌桶限速器：每秒补满 ratePerSec 个令牌，Take会在
+// 令牌不足时阻塞等待，从而把读取速率限制在 ratePerSec 字节/秒左右。
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     int64
+	ratePerSec int64
+	last       time.Time
+}
+
+func newTokenBucket(ratePerSec int64) *tokenBucket {
+	return &tokenBucket{tokens: ratePerSec, ratePerSec: ratePerSec, last: time.Now()}
+}
+
+// Take消耗n个令牌，按ratePerSec字节/秒限速阻塞等待。桶容量封顶在ratePerSec，单次request
+// 超过ratePerSec时（比如SpeedLimit配得比io.Copy一次Read的缓冲区还小）永远凑不满n个令牌，
+// 会在takeChunk里死等——因此这里先把n拆成不超过ratePerSec的子请求，依次喂给takeChunk，
+// 而不是让一次大额请求卡在一个凑不满的桶容量上。
+func (b *tokenBucket) Take(n int64) {
+	if b.ratePerSec <= 0 {
+		return
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > b.ratePerSec {
+			chunk = b.ratePerSec
+		}
+		b.takeChunk(chunk)
+		n -= chunk
+	}
+}
+
+func (b *tokenBucket) takeChunk(n int64) {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		elapsed := now.Sub(b.last)
+		b.last = now
+		b.tokens += int64(elapsed.Seconds() * float64(b.ratePerSec))
+		if b.tokens > b.ratePerSec {
+			b.tokens = b.ratePerSec
+		}
+		if b.tokens >= n {
+			b.tokens -= n
+			b.mu.Unlock()
+			return
+		}
+		wait := time.Duration(float64(n-b.tokens) / float64(b.ratePerSec) * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// rateLimitedReadCloser 把上游响应体包一层令牌桶限速，实现 Addition.SpeedLimit
+// 约定的KB/s级下载限速（proxy/stream模式下才会生效）。
+type rateLimitedReadCloser struct {
+	io.ReadCloser
+	bucket *tokenBucket
+}
+
+func (r *rateLimitedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.bucket.Take(int64(n))
+	}
+	return n, err
+}
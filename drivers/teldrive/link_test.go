@@ -0,0 +1,38 @@
+package teldrive
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTokenBucketTakeLargerThanRate复现了review指出的死锁：SpeedLimit配得比单次Read的
+// 缓冲区还小时，一次Take的请求量会超过桶的容量上限，在拆分修复之前会永远凑不够令牌。
+func TestTokenBucketTakeLargerThanRate(t *testing.T) {
+	b := newTokenBucket(10240)
+
+	done := make(chan struct{})
+	go func() {
+		b.Take(32768)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Take(32768) against ratePerSec=10240 did not return within 5s (deadlocked)")
+	}
+}
+
+func TestTokenBucketZeroRateIsNoop(t *testing.T) {
+	b := newTokenBucket(0)
+	done := make(chan struct{})
+	go func() {
+		b.Take(1 << 30)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Take should be a no-op when ratePerSec <= 0")
+	}
+}
@@ -8,16 +8,27 @@ import (
 	"github.com/AliciaLEO/alist-pro/v3/pkg/utils"
 )
 
+// MediaInfo 是图片/视频类对象的基础媒体信息，只有开启EnableThumbnails时才会被填充。
+type MediaInfo struct {
+	Duration float64 `json:"duration,omitempty"` // 秒，仅视频有意义
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Mime     string  `json:"mime,omitempty"`
+}
+
 // Object TelDrive对象结构体
 type Object struct {
-	ID       string
-	Name     string
-	Size     int64
-	ModTime  time.Time
-	IsFolder bool
-	Path     string
-	ParentID string
-	driver   *TelDrive
+	ID        string
+	Name      string
+	Size      int64
+	Modified  time.Time
+	IsFolder  bool
+	Path      string
+	ParentID  string
+	MimeType  string
+	Thumbnail string
+	Media     *MediaInfo
+	driver    *TelDrive
 }
 
 // GetSize 获取文件大小
@@ -32,12 +43,12 @@ func (o *Object) GetName() string {
 
 // ModTime 获取修改时间
 func (o *Object) ModTime() time.Time {
-	return o.ModTime
+	return o.Modified
 }
 
 // CreateTime 获取创建时间（TelDrive API不提供创建时间，使用修改时间代替）
 func (o *Object) CreateTime() time.Time {
-	return o.ModTime
+	return o.Modified
 }
 
 // IsDir 是否是目录
@@ -60,17 +71,23 @@ func (o *Object) GetPath() string {
 	return o.Path
 }
 
+// Thumb 实现model.Thumb，返回缩略图URL；未开启EnableThumbnails或非图片/视频时为空字符串。
+func (o *Object) Thumb() string {
+	return o.Thumbnail
+}
+
 // GetRoot 获取根目录对象
 func (d *TelDrive) GetRoot(ctx context.Context) (model.Obj, error) {
 	return &Object{
 		ID:       "root",
 		Name:     "",
 		Size:     0,
-		ModTime:  time.Now(),
+		Modified: time.Now(),
 		IsFolder: true,
 		Path:     "/",
 		driver:   d,
 	}, nil
 }
 
-var _ model.Obj = (*Object)(nil)
\ No newline at end of file
+var _ model.Obj = (*Object)(nil)
+var _ model.Thumb = (*Object)(nil)
\ No newline at end of file
@@ -0,0 +1,179 @@
+package teldrive
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/model"
+)
+
+// thumbnailResponse是/api/files/{id}/thumbnail在生成缩略图的同时顺带返回的媒体信息，
+// 字段都是可选的——图片没有duration，纯缩略图接口也可能什么元数据都不带。
+type thumbnailResponse struct {
+	URL      string  `json:"url"`
+	Duration float64 `json:"duration,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Mime     string  `json:"mime,omitempty"`
+}
+
+// thumbnailCacheMaxEntries 限制缩略图LRU缓存的条目数，不对外暴露成配置项，
+// 避免Addition为了一个边角场景膨胀出更多选项。
+const thumbnailCacheMaxEntries = 2000
+
+type thumbnailCacheKey struct {
+	id    string
+	mtime int64
+}
+
+type thumbnailCacheEntry struct {
+	key     thumbnailCacheKey
+	url     string
+	media   *MediaInfo
+	expires time.Time
+}
+
+// thumbnailLRU是一个按{id, mtime}为键、容量受限的内存LRU缓存，mtime参与键意味着
+// 文件被替换后旧缩略图会自然失效，不需要额外的失效通知机制。
+// 每个*TelDrive实例持有自己的thumbnailLRU而不是共享包级变量：不同存储挂载的文件ID
+// 命名空间互不相通，共享缓存会让一个挂载读到另一个挂载缓存的缩略图/媒体信息。
+type thumbnailLRU struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[thumbnailCacheKey]*list.Element
+}
+
+func newThumbnailLRU() *thumbnailLRU {
+	return &thumbnailLRU{
+		ll:    list.New(),
+		items: make(map[thumbnailCacheKey]*list.Element),
+	}
+}
+
+func (c *thumbnailLRU) get(key thumbnailCacheKey) (string, *MediaInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", nil, false
+	}
+	entry := el.Value.(*thumbnailCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return "", nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.url, entry.media, true
+}
+
+func (c *thumbnailLRU) set(key thumbnailCacheKey, url string, media *MediaInfo, ttlSeconds int) {
+	if ttlSeconds <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*thumbnailCacheEntry)
+		entry.url = url
+		entry.media = media
+		entry.expires = time.Now().Add(time.Duration(ttlSeconds) * time.Second)
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	entry := &thumbnailCacheEntry{key: key, url: url, media: media, expires: time.Now().Add(time.Duration(ttlSeconds) * time.Second)}
+	el := c.ll.PushFront(entry)
+	c.items[key] = el
+
+	for c.ll.Len() > thumbnailCacheMaxEntries {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*thumbnailCacheEntry).key)
+	}
+}
+
+// fillThumbnails 对List结果中属于图片/视频的条目并发拉取缩略图URL，并发数受UploadConcurrency
+// 约束，复用同一个配置项而不是再引入一个新的并发度选项。拉取失败的条目直接跳过，
+// 不影响List本身的结果。
+func (d *TelDrive) fillThumbnails(ctx context.Context, files []model.Obj) {
+	concurrency := d.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, f := range files {
+		obj, ok := f.(*Object)
+		if !ok || obj.IsFolder || !isThumbnailable(obj.MimeType) {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(obj *Object) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.fetchThumbnail(ctx, obj)
+		}(obj)
+	}
+
+	wg.Wait()
+}
+
+func isThumbnailable(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/")
+}
+
+func (d *TelDrive) fetchThumbnail(ctx context.Context, obj *Object) {
+	key := thumbnailCacheKey{id: obj.ID, mtime: obj.ModTime().Unix()}
+	if url, media, ok := d.thumbCache.get(key); ok {
+		obj.Thumbnail = url
+		obj.Media = media
+		return
+	}
+
+	size := d.ThumbnailSize
+	if size == "" {
+		size = "small"
+	}
+
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetQueryParam("size", size).
+		Get(fmt.Sprintf("/api/files/%s/thumbnail", obj.ID))
+	if err != nil || resp.StatusCode() != 200 {
+		return
+	}
+
+	var parsed thumbnailResponse
+	var media *MediaInfo
+	url := ""
+	if json.Unmarshal(resp.Body(), &parsed) == nil && parsed.URL != "" {
+		url = parsed.URL
+		media = &MediaInfo{Duration: parsed.Duration, Width: parsed.Width, Height: parsed.Height, Mime: parsed.Mime}
+	} else {
+		url = resp.Header().Get("Location")
+		if url == "" {
+			url = resp.String()
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	obj.Thumbnail = url
+	obj.Media = media
+	d.thumbCache.set(key, url, media, d.ThumbnailCacheTTL)
+}
@@ -0,0 +1,356 @@
+package teldrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AliciaLEO/alist-pro/v3/internal/driver"
+	"github.com/google/uuid"
+)
+
+// 分块上传重试退避参数，MaxUploadRetries 之外的细节不暴露给用户，避免 Addition 过度膨胀。
+const (
+	backoffInitialDelay = 500 * time.Millisecond
+	backoffMaxDelay     = 30 * time.Second
+	backoffJitterRatio  = 0.2
+)
+
+// chunkJob 描述一个待上传的分块，数据落在磁盘临时文件上而不是常驻内存，
+// 这样默认 500MB 的分块大小也不会把上传进程的内存撑爆。
+type chunkJob struct {
+	chunkNo int
+	path    string
+	size    int64
+	md5     string
+}
+
+// chunkResult 是某个 chunkJob 的上传结果。
+type chunkResult struct {
+	chunkNo int
+	part    PartFile
+	err     error
+}
+
+// uploadState 是落盘的断点续传状态，使得一次被中断的 Put 不仅能在单次调用内重试，
+// 还能在 alist 重启之后凭同样的 uploadID 继续上传。
+type uploadState struct {
+	UploadID string           `json:"uploadId"`
+	FileName string           `json:"fileName"`
+	FileSize int64            `json:"fileSize"`
+	Parts    map[int]PartFile `json:"parts"`
+}
+
+func uploadStateDir() string {
+	return filepath.Join(os.TempDir(), "alist-teldrive-uploads")
+}
+
+func uploadStatePath(uploadID string) string {
+	return filepath.Join(uploadStateDir(), uploadID+".json")
+}
+
+func loadUploadState(uploadID string) *uploadState {
+	b, err := os.ReadFile(uploadStatePath(uploadID))
+	if err != nil {
+		return nil
+	}
+	var st uploadState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil
+	}
+	return &st
+}
+
+func saveUploadState(st *uploadState) error {
+	if err := os.MkdirAll(uploadStateDir(), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploadStatePath(st.UploadID), b, 0644)
+}
+
+func removeUploadState(uploadID string) {
+	_ = os.Remove(uploadStatePath(uploadID))
+}
+
+// isRetryableErr 判断一次分块上传失败是否值得重试：网络错误和 5xx 视为可重试，
+// 4xx 被服务端拒绝则视为致命错误，重试没有意义。
+func isRetryableErr(statusCode int, err error) bool {
+	if statusCode >= 400 && statusCode < 500 {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return statusCode >= 500
+}
+
+// backoffDelay 计算第 attempt 次重试前的等待时间：指数退避加一点随机抖动，避免多个分块同时重试打爆服务端。
+func backoffDelay(attempt int) time.Duration {
+	d := float64(backoffInitialDelay) * math.Pow(2, float64(attempt))
+	if d > float64(backoffMaxDelay) {
+		d = float64(backoffMaxDelay)
+	}
+	jitter := d * backoffJitterRatio * (rand.Float64()*2 - 1)
+	d += jitter
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// writeChunkToDisk 从 file 中读取最多 n 字节写入临时文件，并在读取的同时滚动计算 MD5，
+// 这样分块在落盘完成的那一刻就已经知道自己的校验值，无需再次整体读取。
+func writeChunkToDisk(file io.Reader, n int64, chunkNo int) (*chunkJob, error) {
+	tmp, err := os.CreateTemp("", fmt.Sprintf("teldrive-chunk-%03d-*.bin", chunkNo))
+	if err != nil {
+		return nil, fmt.Errorf("创建分块临时文件失败: %v", err)
+	}
+	defer tmp.Close()
+
+	h := md5.New()
+	written, err := io.Copy(tmp, io.TeeReader(io.LimitReader(file, n), h))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("写入分块临时文件失败: %v", err)
+	}
+
+	return &chunkJob{
+		chunkNo: chunkNo,
+		path:    tmp.Name(),
+		size:    written,
+		md5:     hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// uploadChunk 上传单个分块，内部自带指数退避重试；4xx 错误被视为致命错误，直接返回不再重试。
+// 分块自身的 MD5 以 md5 参数随上传请求发给服务端；本函数不会单独发校验请求，也不会比对服务端
+// 返回的校验和，是否重试只取决于网络错误和响应状态码。
+func (d *TelDrive) uploadChunk(ctx context.Context, uploadID string, job chunkJob, channelID int64, fileName string) (PartFile, error) {
+	chunkName := fileName
+	if d.RandomChunkName {
+		chunkName = getMD5Hash(uuid.New().String())
+	} else if job.chunkNo > 0 {
+		chunkName = fmt.Sprintf("%s.part.%03d", fileName, job.chunkNo)
+	}
+
+	uploadURL := d.ApiHost + "/api/uploads/" + uploadID
+	if d.UploadHost != "" {
+		uploadURL = d.UploadHost + "/api/uploads/" + uploadID
+	}
+
+	params := url.Values{}
+	params.Add("partName", chunkName)
+	params.Add("fileName", fileName)
+	params.Add("partNo", strconv.Itoa(job.chunkNo))
+	params.Add("channelId", strconv.FormatInt(channelID, 10))
+	params.Add("encrypted", strconv.FormatBool(d.EncryptFiles))
+	params.Add("md5", job.md5)
+
+	maxRetries := d.MaxUploadRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return PartFile{}, ctx.Err()
+			case <-time.After(backoffDelay(attempt - 1)):
+			}
+		}
+
+		partInfo, statusCode, err := d.postChunk(ctx, uploadURL, job, params)
+		if err == nil {
+			partInfo.PartNo = job.chunkNo
+			return partInfo, nil
+		}
+
+		lastErr = err
+		if !isRetryableErr(statusCode, err) {
+			return PartFile{}, lastErr
+		}
+	}
+
+	return PartFile{}, fmt.Errorf("分块 %d 重试 %d 次后仍然失败: %v", job.chunkNo, maxRetries, lastErr)
+}
+
+func (d *TelDrive) postChunk(ctx context.Context, uploadURL string, job chunkJob, params url.Values) (PartFile, int, error) {
+	f, err := os.Open(job.path)
+	if err != nil {
+		return PartFile{}, 0, fmt.Errorf("打开分块临时文件失败: %v", err)
+	}
+	defer f.Close()
+
+	resp, err := d.client.R().
+		SetContext(ctx).
+		SetQueryParamsFromValues(params).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetContentLength(true).
+		SetBody(f).
+		Post(uploadURL)
+
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode()
+	}
+
+	if err != nil {
+		return PartFile{}, statusCode, err
+	}
+	if statusCode != 200 {
+		return PartFile{}, statusCode, fmt.Errorf("上传分块失败: %s", resp.String())
+	}
+
+	var partInfo PartFile
+	if err := json.Unmarshal(resp.Body(), &partInfo); err != nil {
+		return PartFile{}, statusCode, fmt.Errorf("解析分块信息失败: %v", err)
+	}
+	if partInfo.PartId == 0 {
+		return PartFile{}, statusCode, fmt.Errorf("上传分块失败: 未获取到分块ID")
+	}
+	return partInfo, statusCode, nil
+}
+
+// runUploadPipeline 把 file 切分成固定大小的分块，落盘后交给最多 UploadConcurrency 个 worker 并行上传，
+// 并通过 uploadState 记录已完成的分块，使中途失败的上传可以在下一次 Put 调用（哪怕跨进程重启）时跳过
+// 已经成功的部分，只补传剩余分块。
+func (d *TelDrive) runUploadPipeline(ctx context.Context, uploadID string, channelID int64, file io.Reader, fileName string, fileSize, chunkSize int64, up driver.UpdateProgress) ([]PartFile, error) {
+	totalChunks := (fileSize + chunkSize - 1) / chunkSize
+	if fileSize == 0 {
+		totalChunks = 1
+	}
+
+	existingChunks := make(map[int]PartFile)
+	if st := loadUploadState(uploadID); st != nil && st.FileName == fileName && st.FileSize == fileSize {
+		existingChunks = st.Parts
+	}
+	if resp, err := d.client.R().Get("/api/uploads/" + uploadID); err == nil && resp.StatusCode() == 200 {
+		var parts []PartFile
+		if json.Unmarshal(resp.Body(), &parts) == nil {
+			for _, part := range parts {
+				existingChunks[part.PartNo] = part
+			}
+		}
+	}
+
+	concurrency := d.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	jobs := make(chan chunkJob, concurrency)
+	results := make(chan chunkResult, concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				part, err := d.uploadChunk(ctx, uploadID, job, channelID, fileName)
+				os.Remove(job.path)
+				results <- chunkResult{chunkNo: job.chunkNo, part: part, err: err}
+			}
+		}()
+	}
+
+	st := &uploadState{UploadID: uploadID, FileName: fileName, FileSize: fileSize, Parts: map[int]PartFile{}}
+	for no, part := range existingChunks {
+		st.Parts[no] = part
+	}
+
+	var uploadedSize atomic.Int64
+
+	// 生产者按顺序读取底层流，已上传过的分块直接丢弃对应字节（保持流位置同步），
+	// 其余分块落盘后交给worker。一旦发生不可恢复的读取错误，后续分块也会被标记为
+	// 失败而不是静默挂起，确保下面的消费循环总能收到 totalChunks 条结果。
+	go func() {
+		defer close(jobs)
+		var read int64
+		failedFrom := -1
+		for chunkNo := 1; chunkNo <= int(totalChunks); chunkNo++ {
+			n := chunkSize
+			if chunkNo == int(totalChunks) {
+				n = fileSize - read
+			}
+			read += n
+
+			if failedFrom >= 0 {
+				results <- chunkResult{chunkNo: chunkNo, err: fmt.Errorf("前序分块读取失败，放弃后续分块")}
+				continue
+			}
+
+			if existing, ok := existingChunks[chunkNo]; ok {
+				io.CopyN(io.Discard, file, n)
+				results <- chunkResult{chunkNo: chunkNo, part: existing}
+				continue
+			}
+
+			job, err := writeChunkToDisk(file, n, chunkNo)
+			if err != nil {
+				failedFrom = chunkNo
+				results <- chunkResult{chunkNo: chunkNo, err: err}
+				continue
+			}
+			select {
+			case jobs <- *job:
+			case <-ctx.Done():
+				os.Remove(job.path)
+				failedFrom = chunkNo
+				results <- chunkResult{chunkNo: chunkNo, err: ctx.Err()}
+			}
+		}
+	}()
+
+	partsByNo := make(map[int]PartFile)
+	var firstErr error
+	for remaining := int(totalChunks); remaining > 0; remaining-- {
+		res := <-results
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("分块 %d 上传失败: %v", res.chunkNo, res.err)
+			}
+			continue
+		}
+		partsByNo[res.chunkNo] = res.part
+		st.Parts[res.chunkNo] = res.part
+		_ = saveUploadState(st)
+		if res.part.Size > 0 {
+			uploadedSize.Add(res.part.Size)
+			up(float64(uploadedSize.Load()) / float64(fileSize) * 100)
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	parts := make([]PartFile, 0, len(partsByNo))
+	for _, part := range partsByNo {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNo < parts[j].PartNo })
+
+	removeUploadState(uploadID)
+	return parts, nil
+}
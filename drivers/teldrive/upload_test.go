@@ -0,0 +1,87 @@
+package teldrive
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableErr(t *testing.T) {
+	cases := []struct {
+		name       string
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{"network error", 0, errors.New("dial tcp: connection reset"), true},
+		{"5xx", 503, nil, true},
+		{"4xx", 404, nil, false},
+		{"2xx", 200, nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryableErr(c.statusCode, c.err); got != c.want {
+				t.Errorf("isRetryableErr(%d, %v) = %v, want %v", c.statusCode, c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoffDelay(attempt)
+		if d < 0 {
+			t.Fatalf("backoffDelay(%d) = %v, want >= 0", attempt, d)
+		}
+		max := time.Duration(float64(backoffMaxDelay) * (1 + backoffJitterRatio))
+		if d > max {
+			t.Fatalf("backoffDelay(%d) = %v, want <= %v", attempt, d, max)
+		}
+	}
+}
+
+func TestUploadStateRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "teldrive-upload-state-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploadID := "test-upload-id"
+	st := &uploadState{
+		UploadID: uploadID,
+		FileName: "movie.mkv",
+		FileSize: 12345,
+		Parts: map[int]PartFile{
+			1: {Name: "movie.mkv", PartId: 1, PartNo: 1, Size: 100},
+		},
+	}
+
+	if err := saveUploadState(st); err != nil {
+		t.Fatalf("saveUploadState: %v", err)
+	}
+	defer removeUploadState(uploadID)
+
+	loaded := loadUploadState(uploadID)
+	if loaded == nil {
+		t.Fatal("loadUploadState returned nil after saveUploadState")
+	}
+	if loaded.FileName != st.FileName || loaded.FileSize != st.FileSize {
+		t.Fatalf("loadUploadState = %+v, want FileName=%q FileSize=%d", loaded, st.FileName, st.FileSize)
+	}
+	if loaded.Parts[1].PartId != 1 {
+		t.Fatalf("loadUploadState parts = %+v, want part 1 PartId=1", loaded.Parts)
+	}
+
+	removeUploadState(uploadID)
+	if loadUploadState(uploadID) != nil {
+		t.Fatal("loadUploadState should return nil after removeUploadState")
+	}
+}
+
+func TestLoadUploadStateMissing(t *testing.T) {
+	if st := loadUploadState("does-not-exist"); st != nil {
+		t.Fatalf("loadUploadState(missing) = %+v, want nil", st)
+	}
+}